@@ -0,0 +1,159 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"image"
+	"sync"
+
+	"github.com/hajimehoshi/ebiten/internal/graphics"
+)
+
+// atlasPageSize is the side length, in pixels, of a single shared atlas
+// texture page.
+const atlasPageSize = 1024
+
+// atlasMaxImageSize is the largest image width or height that is eligible
+// for atlas packing. Larger images get their own texture: there is little
+// batching benefit, and they would fragment a page for everyone else.
+const atlasMaxImageSize = 256
+
+// shelf is one horizontal strip of an atlasPage's shelf packer.
+type shelf struct {
+	y      int
+	height int
+	nextX  int
+}
+
+// atlasPage packs many small images into a single shared GL texture using a
+// shelf (row) bin packer: images are placed left-to-right on the first
+// shelf, top to bottom, that they fit, and a new shelf is opened under the
+// previous one when none fits. Keeping many sprites in one texture unit lets the graphics
+// backend coalesce consecutive draws against the page into a single draw
+// call instead of rebinding a texture per sprite.
+type atlasPage struct {
+	image   *graphics.Image
+	shelves []*shelf
+
+	// free holds regions released by freeRegion that haven't been reused
+	// yet, so a disposed image's space can be reclaimed by a later alloc
+	// instead of the shelves only ever growing.
+	free []image.Rectangle
+}
+
+func newAtlasPage(filter Filter) (*atlasPage, error) {
+	img, err := graphics.NewImage(atlasPageSize, atlasPageSize, glFilter(filter))
+	if err != nil {
+		return nil, err
+	}
+	return &atlasPage{image: img}, nil
+}
+
+// alloc reserves a w x h region on the page and returns its top-left
+// position. ok is false if the page has no shelf, free region, or room left
+// for it.
+func (p *atlasPage) alloc(w, h int) (x, y int, ok bool) {
+	for idx, r := range p.free {
+		if w <= r.Dx() && h <= r.Dy() {
+			p.free = append(p.free[:idx], p.free[idx+1:]...)
+			return r.Min.X, r.Min.Y, true
+		}
+	}
+	for _, s := range p.shelves {
+		if h <= s.height && s.nextX+w <= atlasPageSize {
+			x, y = s.nextX, s.y
+			s.nextX += w
+			return x, y, true
+		}
+	}
+	top := 0
+	if n := len(p.shelves); n > 0 {
+		last := p.shelves[n-1]
+		top = last.y + last.height
+	}
+	if top+h > atlasPageSize || w > atlasPageSize {
+		return 0, 0, false
+	}
+	p.shelves = append(p.shelves, &shelf{y: top, height: h, nextX: w})
+	return 0, top, true
+}
+
+// freeRegion returns r to the page so a later alloc can reclaim it. It does
+// not shrink the shelves themselves, only makes r available for reuse.
+func (p *atlasPage) freeRegion(r image.Rectangle) {
+	p.free = append(p.free, r)
+}
+
+// atlas is the process-wide collection of atlas pages used to pack small,
+// non-volatile images.
+type atlas struct {
+	m     sync.Mutex
+	pages []*atlasPage
+}
+
+var theAtlas = &atlas{}
+
+// alloc tries to place a width x height image into one of the existing
+// pages, creating a new page if none has room. It returns the owning page
+// and the image's offset within it.
+func (a *atlas) alloc(width, height int, filter Filter) (page *atlasPage, x, y int, ok bool) {
+	if atlasPageSize < width || atlasPageSize < height {
+		return nil, 0, 0, false
+	}
+	a.m.Lock()
+	defer a.m.Unlock()
+	for _, p := range a.pages {
+		if px, py, ok := p.alloc(width, height); ok {
+			return p, px, py, true
+		}
+	}
+	p, err := newAtlasPage(filter)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	a.pages = append(a.pages, p)
+	x, y, ok = p.alloc(width, height)
+	return p, x, y, ok
+}
+
+// release returns region on page to the atlas so a later alloc can reclaim
+// it, instead of the page's shelves only ever growing as sprites come and
+// go.
+func (a *atlas) release(page *atlasPage, region image.Rectangle) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	page.freeRegion(region)
+}
+
+// newPackedImage creates the GL texture backing a non-volatile image,
+// transparently packing it into a shared atlas page when it is small
+// enough, and falling back to its own texture otherwise. page and region
+// are non-nil/non-zero only when the image was atlas-packed; the caller
+// must release them back to the atlas when the image is disposed instead
+// of disposing the view's GL texture directly, since that would destroy
+// the whole shared page.
+func newPackedImage(width, height int, filter Filter) (img *graphics.Image, page *atlasPage, region image.Rectangle, err error) {
+	if width <= atlasMaxImageSize && height <= atlasMaxImageSize {
+		if p, x, y, ok := theAtlas.alloc(width, height, filter); ok {
+			img, err = graphics.NewImageInAtlas(p.image, x, y, width, height, glFilter(filter))
+			if err != nil {
+				return nil, nil, image.Rectangle{}, err
+			}
+			return img, p, image.Rect(x, y, x+width, y+height), nil
+		}
+	}
+	img, err = graphics.NewImage(width, height, glFilter(filter))
+	return img, nil, image.Rectangle{}, err
+}
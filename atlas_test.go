@@ -0,0 +1,72 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"image"
+	"testing"
+)
+
+func TestAtlasPageAllocPacksShelves(t *testing.T) {
+	p := &atlasPage{}
+
+	x1, y1, ok := p.alloc(10, 20)
+	if !ok || x1 != 0 || y1 != 0 {
+		t.Fatalf("alloc(10,20) = (%d,%d,%v), want (0,0,true)", x1, y1, ok)
+	}
+	x2, y2, ok := p.alloc(10, 15)
+	if !ok || x2 != 10 || y2 != 0 {
+		t.Fatalf("alloc(10,15) = (%d,%d,%v), want (10,0,true): it should share the first shelf", x2, y2, ok)
+	}
+	x3, y3, ok := p.alloc(5, 30)
+	if !ok || x3 != 0 || y3 != 20 {
+		t.Fatalf("alloc(5,30) = (%d,%d,%v), want (0,20,true): it's too tall for the first shelf, so a new one opens below it", x3, y3, ok)
+	}
+}
+
+func TestAtlasPageAllocFailsWhenOversized(t *testing.T) {
+	p := &atlasPage{}
+	if _, _, ok := p.alloc(atlasPageSize+1, 10); ok {
+		t.Error("alloc with width > atlasPageSize succeeded, want failure")
+	}
+}
+
+func TestAtlasPageFreeRegionIsReusedByAlloc(t *testing.T) {
+	p := &atlasPage{}
+	x, y, ok := p.alloc(10, 10)
+	if !ok {
+		t.Fatal("alloc(10,10) failed")
+	}
+	p.freeRegion(image.Rect(x, y, x+10, y+10))
+
+	x2, y2, ok := p.alloc(8, 8)
+	if !ok || x2 != x || y2 != y {
+		t.Fatalf("alloc(8,8) after freeRegion = (%d,%d,%v), want (%d,%d,true): it should reclaim the freed region instead of opening a new shelf", x2, y2, ok, x, y)
+	}
+}
+
+func TestAtlasPageFreeRegionIsNotReusedWhenTooSmall(t *testing.T) {
+	p := &atlasPage{}
+	x, y, ok := p.alloc(4, 4)
+	if !ok {
+		t.Fatal("alloc(4,4) failed")
+	}
+	p.freeRegion(image.Rect(x, y, x+4, y+4))
+
+	x2, y2, ok := p.alloc(8, 8)
+	if !ok || x2 == x && y2 == y {
+		t.Fatalf("alloc(8,8) after freeing a 4x4 region = (%d,%d,%v), want a region the 4x4 free entry can't satisfy", x2, y2, ok)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"fmt"
+
+	"github.com/hajimehoshi/ebiten/internal/graphics/opengl"
+)
+
+// GLBlendFactor represents a GL blending factor used in a BlendFunc.
+type GLBlendFactor int
+
+// GLBlendFactors
+const (
+	GLBlendFactorZero GLBlendFactor = iota
+	GLBlendFactorOne
+	GLBlendFactorSrcColor
+	GLBlendFactorOneMinusSrcColor
+	GLBlendFactorSrcAlpha
+	GLBlendFactorOneMinusSrcAlpha
+	GLBlendFactorDstColor
+	GLBlendFactorOneMinusDstColor
+	GLBlendFactorDstAlpha
+	GLBlendFactorOneMinusDstAlpha
+
+	glBlendFactorCount
+)
+
+// GLBlendEquation represents a GL blend equation used in a BlendFunc.
+type GLBlendEquation int
+
+// GLBlendEquations
+const (
+	GLBlendEquationAdd GLBlendEquation = iota
+	GLBlendEquationSubtract
+	GLBlendEquationReverseSubtract
+	GLBlendEquationMin
+	GLBlendEquationMax
+
+	glBlendEquationCount
+)
+
+// BlendFunc fully describes a GL blend state: the source and destination
+// factors and the equation used for the RGB and alpha channels,
+// independently. It supersedes the fixed CompositeMode presets for effects
+// CompositeMode can't express, such as additive, multiply, screen,
+// subtract, and min/max blending.
+//
+// When a DrawImageOptions has a non-nil BlendFunc, it takes precedence over
+// CompositeMode.
+type BlendFunc struct {
+	SrcRGB   GLBlendFactor
+	DstRGB   GLBlendFactor
+	SrcAlpha GLBlendFactor
+	DstAlpha GLBlendFactor
+
+	EquationRGB   GLBlendEquation
+	EquationAlpha GLBlendEquation
+}
+
+// validate reports an error if any field of b falls outside its defined
+// enum range.
+func (b *BlendFunc) validate() error {
+	factors := []struct {
+		name string
+		f    GLBlendFactor
+	}{
+		{"SrcRGB", b.SrcRGB}, {"DstRGB", b.DstRGB},
+		{"SrcAlpha", b.SrcAlpha}, {"DstAlpha", b.DstAlpha},
+	}
+	for _, e := range factors {
+		if e.f < 0 || glBlendFactorCount <= e.f {
+			return fmt.Errorf("ebiten: BlendFunc.%s is not a valid GLBlendFactor: %d", e.name, e.f)
+		}
+	}
+	equations := []struct {
+		name string
+		e    GLBlendEquation
+	}{
+		{"EquationRGB", b.EquationRGB}, {"EquationAlpha", b.EquationAlpha},
+	}
+	for _, e := range equations {
+		if e.e < 0 || glBlendEquationCount <= e.e {
+			return fmt.Errorf("ebiten: BlendFunc.%s is not a valid GLBlendEquation: %d", e.name, e.e)
+		}
+	}
+	return nil
+}
+
+// convertBlendFunc converts the public BlendFunc into the internal
+// representation consumed by the GL backend.
+func convertBlendFunc(b BlendFunc) opengl.BlendFunc {
+	return opengl.BlendFunc{
+		SrcRGB:   opengl.BlendFactor(b.SrcRGB),
+		DstRGB:   opengl.BlendFactor(b.DstRGB),
+		SrcAlpha: opengl.BlendFactor(b.SrcAlpha),
+		DstAlpha: opengl.BlendFactor(b.DstAlpha),
+
+		EquationRGB:   opengl.BlendEquation(b.EquationRGB),
+		EquationAlpha: opengl.BlendEquation(b.EquationAlpha),
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import "testing"
+
+func TestBlendFuncValidateAcceptsZeroValue(t *testing.T) {
+	b := &BlendFunc{}
+	if err := b.validate(); err != nil {
+		t.Errorf("validate() = %v, want nil", err)
+	}
+}
+
+func TestBlendFuncValidateRejectsFactorAtOrAboveCount(t *testing.T) {
+	b := &BlendFunc{SrcRGB: glBlendFactorCount}
+	if err := b.validate(); err == nil {
+		t.Error("validate() = nil, want an error: SrcRGB is out of range")
+	}
+}
+
+func TestBlendFuncValidateRejectsNegativeFactor(t *testing.T) {
+	b := &BlendFunc{DstAlpha: -1}
+	if err := b.validate(); err == nil {
+		t.Error("validate() = nil, want an error: DstAlpha is negative")
+	}
+}
+
+func TestBlendFuncValidateRejectsEquationAtOrAboveCount(t *testing.T) {
+	b := &BlendFunc{EquationAlpha: glBlendEquationCount}
+	if err := b.validate(); err == nil {
+		t.Error("validate() = nil, want an error: EquationAlpha is out of range")
+	}
+}
+
+func TestBlendFuncValidateRejectsNegativeEquation(t *testing.T) {
+	b := &BlendFunc{EquationRGB: -1}
+	if err := b.validate(); err == nil {
+		t.Error("validate() = nil, want an error: EquationRGB is negative")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"image"
+)
+
+// ReplacePixelsAt replaces the pixels of the sub-rectangle r of the image
+// with p, where p is a byte slice of RGBA pixel values ordered row by row
+// starting at r.Min.
+//
+// Unlike ReplacePixels, only the given rectangle is uploaded to the GPU,
+// which makes repeated small updates (tilemap edits, procedural terrain,
+// software-rendered overlays) much cheaper than replacing the whole image.
+func (i *Image) ReplacePixelsAt(p []uint8, r image.Rectangle) error {
+	return i.impl.ReplacePixelsAt(p, r)
+}
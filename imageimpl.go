@@ -29,41 +29,118 @@ import (
 	"github.com/hajimehoshi/ebiten/internal/ui"
 )
 
+// historyItem is an operation that was applied to an imageImpl's GL texture
+// since it was last fully materialized from i.pixels/i.baseColor. The log is
+// replayed in order by restore after a GL context loss.
+type historyItem interface {
+	isHistoryItem()
+}
+
 type drawImageHistoryItem struct {
-	image    *Image
-	vertices []int16
-	geom     GeoM
-	colorm   ColorM
-	mode     opengl.CompositeMode
+	image     *Image
+	vertices  []int16
+	geom      GeoM
+	colorm    ColorM
+	mode      opengl.CompositeMode
+	blendFunc *BlendFunc
+	shader    *Shader
+	uniforms  []Uniform
 }
 
+func (*drawImageHistoryItem) isHistoryItem() {}
+
+// dstBounds returns the bounding box, in destination image coordinates, that
+// this draw touched. It lets ReplacePixelsAt decide whether a dirty-rect
+// upload can coexist with this entry instead of invalidating the whole log.
+//
+// vertices are in the source image's local, untransformed space; geom is
+// what actually places them in the destination, so each corner must be
+// mapped through geom before the bounding box is taken.
+func (c *drawImageHistoryItem) dstBounds() image.Rectangle {
+	if len(c.vertices) < 4 {
+		return image.ZR
+	}
+	minX, minY := c.geom.Apply(float64(c.vertices[0]), float64(c.vertices[1]))
+	maxX, maxY := minX, minY
+	for k := 4; k+1 < len(c.vertices); k += 4 {
+		x, y := c.geom.Apply(float64(c.vertices[k]), float64(c.vertices[k+1]))
+		if x < minX {
+			minX = x
+		}
+		if maxX < x {
+			maxX = x
+		}
+		if y < minY {
+			minY = y
+		}
+		if maxY < y {
+			maxY = y
+		}
+	}
+	return image.Rect(int(minX), int(minY), int(maxX), int(maxY))
+}
+
+// replacePixelsHistoryItem records a ReplacePixelsAt call so that it can be
+// replayed, in order relative to drawImageHistoryItem entries, after the
+// underlying GL texture is recreated.
+type replacePixelsHistoryItem struct {
+	pixels []uint8
+	rect   image.Rectangle
+}
+
+func (*replacePixelsHistoryItem) isHistoryItem() {}
+
 type imageImpl struct {
-	image            *graphics.Image
-	disposed         bool
-	width            int
-	height           int
-	filter           Filter
-	pixels           []uint8
-	baseColor        color.Color
-	drawImageHistory []*drawImageHistoryItem
-	volatile         bool
-	screen           bool
-	m                sync.Mutex
+	image     *graphics.Image
+	disposed  bool
+	width     int
+	height    int
+	filter    Filter
+	pixels    []uint8
+	baseColor color.Color
+	history   []historyItem
+	volatile  bool
+	screen    bool
+	m         sync.Mutex
+
+	// pooledPage and pooledBucket are set when image is a view into a
+	// texture owned by theVolatileImagePool, so Dispose can hand the page
+	// back to the pool instead of destroying it.
+	pooledPage   *graphics.Image
+	pooledBucket int
+
+	// atlasPage and atlasRegion are set when image is a view into a shared
+	// atlasPage texture, so Dispose can release the region back to the
+	// atlas instead of destroying the page's texture out from under every
+	// other image packed into it.
+	atlasPage   *atlasPage
+	atlasRegion image.Rectangle
+
+	// id is this image's process-wide stable identifier, used to resolve
+	// cross-image references (draw history, shader image uniforms) when
+	// snapshotting and restoring with Snapshot/SnapshotFrame.
+	id uint64
 }
 
 func newImageImpl(width, height int, filter Filter, volatile bool) (*imageImpl, error) {
-	img, err := graphics.NewImage(width, height, glFilter(filter))
+	if volatile {
+		return newVolatileImageImpl(width, height, filter)
+	}
+	img, page, region, err := newPackedImage(width, height, filter)
 	if err != nil {
 		return nil, err
 	}
 	i := &imageImpl{
-		image:    img,
-		width:    width,
-		height:   height,
-		filter:   filter,
-		volatile: volatile,
-		pixels:   make([]uint8, width*height*4),
-	}
+		image:       img,
+		width:       width,
+		height:      height,
+		filter:      filter,
+		volatile:    volatile,
+		pixels:      make([]uint8, width*height*4),
+		atlasPage:   page,
+		atlasRegion: region,
+	}
+	i.id = registerImageImpl(i)
 	runtime.SetFinalizer(i, (*imageImpl).Dispose)
 	return i, nil
 }
@@ -96,6 +173,7 @@ func newImageImplFromImage(source image.Image, filter Filter) (*imageImpl, error
 		filter: filter,
 		pixels: pixels,
 	}
+	i.id = registerImageImpl(i)
 	runtime.SetFinalizer(i, (*imageImpl).Dispose)
 	return i, nil
 }
@@ -113,6 +191,7 @@ func newScreenImageImpl(width, height int) (*imageImpl, error) {
 		screen:   true,
 		pixels:   make([]uint8, width*height*4),
 	}
+	i.id = registerImageImpl(i)
 	runtime.SetFinalizer(i, (*imageImpl).Dispose)
 	return i, nil
 }
@@ -125,7 +204,7 @@ func (i *imageImpl) Fill(clr color.Color) error {
 	}
 	i.pixels = nil
 	i.baseColor = clr
-	i.drawImageHistory = nil
+	i.history = nil
 	return i.image.Fill(clr)
 }
 
@@ -140,7 +219,7 @@ func (i *imageImpl) clearIfVolatile() error {
 	}
 	i.pixels = nil
 	i.baseColor = nil
-	i.drawImageHistory = nil
+	i.history = nil
 	return i.image.Fill(color.Transparent)
 }
 
@@ -175,21 +254,62 @@ func (i *imageImpl) DrawImage(image *Image, options *DrawImageOptions) error {
 	if i.disposed {
 		return errors.New("ebiten: image is already disposed")
 	}
+	if options.BlendFunc != nil {
+		if err := options.BlendFunc.validate(); err != nil {
+			return err
+		}
+		if options.Shader != nil {
+			return errors.New("ebiten: DrawImageOptions.Shader and BlendFunc cannot be used together")
+		}
+	}
 	c := &drawImageHistoryItem{
-		image:    image,
-		vertices: vertices,
-		geom:     options.GeoM,
-		colorm:   options.ColorM,
-		mode:     opengl.CompositeMode(options.CompositeMode),
-	}
-	i.drawImageHistory = append(i.drawImageHistory, c)
-	geom := &options.GeoM
-	colorm := &options.ColorM
-	mode := opengl.CompositeMode(options.CompositeMode)
-	if err := i.image.DrawImage(image.impl.image, vertices, geom, colorm, mode); err != nil {
+		image:     image,
+		vertices:  vertices,
+		geom:      options.GeoM,
+		colorm:    options.ColorM,
+		mode:      opengl.CompositeMode(options.CompositeMode),
+		blendFunc: options.BlendFunc,
+		shader:    options.Shader,
+		uniforms:  options.Uniforms,
+	}
+	i.history = append(i.history, c)
+	return i.applyDrawHistoryItem(c)
+}
+
+// applyDrawHistoryItem issues the GL draw call described by c: through a
+// custom Shader, through an explicit BlendFunc, or through the default
+// texturing shader and CompositeMode preset, in that order of precedence.
+func (i *imageImpl) applyDrawHistoryItem(c *drawImageHistoryItem) error {
+	src := c.image.impl.image
+	geom, colorm := &c.geom, &c.colorm
+	if c.shader != nil {
+		return i.drawImageWithShader(src, c.vertices, geom, colorm, c.mode, c.shader, c.uniforms)
+	}
+	if c.blendFunc != nil {
+		return i.image.DrawImageWithBlendFunc(src, c.vertices, geom, colorm, convertBlendFunc(*c.blendFunc))
+	}
+	return i.image.DrawImage(src, c.vertices, geom, colorm, c.mode)
+}
+
+// drawImageWithShader is like graphics.Image.DrawImage but renders through a
+// user-supplied fragment Shader instead of the default texturing shader.
+func (i *imageImpl) drawImageWithShader(src *graphics.Image, vertices []int16, geom *GeoM, colorm *ColorM, mode opengl.CompositeMode, shader *Shader, uniforms []Uniform) error {
+	program, err := shader.compile(ui.GLContext())
+	if err != nil {
 		return err
 	}
-	return nil
+	gu := make([]graphics.Uniform, len(uniforms))
+	for idx, u := range uniforms {
+		gu[idx] = graphics.Uniform{
+			Name:  u.Name,
+			Value: u.Value,
+			Mat4:  u.Mat4,
+		}
+		if u.Type == UniformImage && u.Image != nil {
+			gu[idx].Image = u.Image.impl.image
+		}
+	}
+	return i.image.DrawImageWithProgram(src, vertices, geom, colorm, mode, program, gu)
 }
 
 func (i *imageImpl) At(x, y int) color.Color {
@@ -201,13 +321,13 @@ func (i *imageImpl) At(x, y int) color.Color {
 	if i.disposed {
 		return color.Transparent
 	}
-	if i.pixels == nil || i.drawImageHistory != nil {
+	if i.pixels == nil || i.history != nil {
 		var err error
 		i.pixels, err = i.image.Pixels(ui.GLContext())
 		if err != nil {
 			panic(err)
 		}
-		i.drawImageHistory = nil
+		i.history = nil
 	}
 	idx := 4*x + 4*y*i.width
 	r, g, b, a := i.pixels[idx], i.pixels[idx+1], i.pixels[idx+2], i.pixels[idx+3]
@@ -215,8 +335,9 @@ func (i *imageImpl) At(x, y int) color.Color {
 }
 
 func (i *imageImpl) hasHistoryWith(target *Image) bool {
-	for _, c := range i.drawImageHistory {
-		if c.image == target {
+	for _, c := range i.history {
+		d, ok := c.(*drawImageHistoryItem)
+		if ok && d.image == target {
 			return true
 		}
 	}
@@ -229,7 +350,7 @@ func (i *imageImpl) resetHistoryIfNeeded(target *Image) error {
 	if i.disposed {
 		return nil
 	}
-	if i.drawImageHistory == nil {
+	if i.history == nil {
 		return nil
 	}
 	if !i.hasHistoryWith(target) {
@@ -241,14 +362,14 @@ func (i *imageImpl) resetHistoryIfNeeded(target *Image) error {
 		return nil
 	}
 	i.baseColor = nil
-	i.drawImageHistory = nil
+	i.history = nil
 	return nil
 }
 
 func (i *imageImpl) hasHistory() bool {
 	i.m.Lock()
 	defer i.m.Unlock()
-	return i.drawImageHistory != nil
+	return i.history != nil
 }
 
 func (i *imageImpl) restore(context *opengl.Context) error {
@@ -283,29 +404,53 @@ func (i *imageImpl) restore(context *opengl.Context) error {
 				img.Pix[4*idx+3] = a
 			}
 		}
+		if i.atlasPage != nil {
+			// The page this image was packed into was invalidated by the
+			// same context loss as i, and every other image sharing it is
+			// restoring independently of this call, so there's no safe way
+			// to reuse or recreate the shared page here. Give i its own
+			// standalone texture instead, and let Dispose release it like
+			// any other non-atlas-packed image.
+			i.atlasPage = nil
+			i.atlasRegion = image.Rectangle{}
+		}
 		var err error
 		i.image, err = graphics.NewImageFromImage(img, glFilter(i.filter))
 		if err != nil {
 			return err
 		}
-		for _, c := range i.drawImageHistory {
-			if c.image.impl.hasHistory() {
-				panic("not reach")
-			}
-			if err := i.image.DrawImage(c.image.impl.image, c.vertices, &c.geom, &c.colorm, c.mode); err != nil {
-				return err
+		for _, item := range i.history {
+			switch c := item.(type) {
+			case *drawImageHistoryItem:
+				if c.image.impl.hasHistory() {
+					panic("not reach")
+				}
+				if err := i.applyDrawHistoryItem(c); err != nil {
+					return err
+				}
+			case *replacePixelsHistoryItem:
+				if err := i.image.ReplacePixelsAt(c.pixels, c.rect); err != nil {
+					return err
+				}
 			}
 		}
-		if 0 < len(i.drawImageHistory) {
+		if 0 < len(i.history) {
 			i.pixels, err = i.image.Pixels(context)
 			if err != nil {
 				return err
 			}
 		}
 		i.baseColor = nil
-		i.drawImageHistory = nil
+		i.history = nil
 		return nil
 	}
+	if i.pooledPage != nil {
+		// Likewise, the pooled page backing i was invalidated by the same
+		// context loss, so it can't be handed back to the pool as-is. Give
+		// i its own standalone texture and let Dispose dispose it directly.
+		i.pooledPage = nil
+		i.pooledBucket = 0
+	}
 	var err error
 	i.image, err = graphics.NewImage(i.width, i.height, glFilter(i.filter))
 	if err != nil {
@@ -320,7 +465,11 @@ func (i *imageImpl) Dispose() error {
 	if i.disposed {
 		return errors.New("ebiten: image is already disposed")
 	}
-	if !i.screen {
+	if i.pooledPage != nil {
+		theVolatileImagePool.release(i.pooledPage, i.pooledBucket)
+	} else if i.atlasPage != nil {
+		theAtlas.release(i.atlasPage, i.atlasRegion)
+	} else if !i.screen {
 		if err := i.image.Dispose(); err != nil {
 			return err
 		}
@@ -329,7 +478,8 @@ func (i *imageImpl) Dispose() error {
 	i.disposed = true
 	i.pixels = nil
 	i.baseColor = nil
-	i.drawImageHistory = nil
+	i.history = nil
+	unregisterImageImpl(i.id)
 	runtime.SetFinalizer(i, nil)
 	return nil
 }
@@ -345,13 +495,91 @@ func (i *imageImpl) ReplacePixels(p []uint8) error {
 	}
 	copy(i.pixels, p)
 	i.baseColor = nil
-	i.drawImageHistory = nil
+	i.history = nil
 	if i.disposed {
 		return errors.New("ebiten: image is already disposed")
 	}
 	return i.image.ReplacePixels(p)
 }
 
+// ReplacePixelsAt replaces the pixels of the sub-rectangle r of the image
+// with p, uploading only that region to the GL texture instead of the whole
+// image.
+//
+// r must be contained in the image's bounds, and len(p) must equal
+// 4*r.Dx()*r.Dy().
+func (i *imageImpl) ReplacePixelsAt(p []uint8, r image.Rectangle) error {
+	if l := 4 * r.Dx() * r.Dy(); len(p) != l {
+		return fmt.Errorf("ebiten: p's length must be %d", l)
+	}
+	if !r.In(image.Rect(0, 0, i.width, i.height)) {
+		return fmt.Errorf("ebiten: rectangle %v is out of the image bounds", r)
+	}
+	i.m.Lock()
+	defer i.m.Unlock()
+	if i.disposed {
+		return errors.New("ebiten: image is already disposed")
+	}
+	intersects := i.historyIntersects(r)
+	if intersects {
+		// A prior draw's destination overlaps the patched region, so the
+		// rest of i.pixels is stale relative to what those draws actually
+		// produced on the GL texture. Read the texture back in full before
+		// discarding the history entries that would otherwise be needed to
+		// reproduce it, the same way resetHistoryIfNeeded does.
+		pixels, err := i.image.Pixels(ui.GLContext())
+		if err != nil {
+			return err
+		}
+		i.pixels = pixels
+	} else if i.pixels == nil {
+		i.pixels = make([]uint8, 4*i.width*i.height)
+		if i.baseColor != nil {
+			r32, g32, b32, a32 := i.baseColor.RGBA()
+			pr, pg, pb, pa := uint8(r32), uint8(g32), uint8(b32), uint8(a32)
+			for idx := 0; idx < len(i.pixels)/4; idx++ {
+				i.pixels[4*idx] = pr
+				i.pixels[4*idx+1] = pg
+				i.pixels[4*idx+2] = pb
+				i.pixels[4*idx+3] = pa
+			}
+		}
+	}
+	for j := 0; j < r.Dy(); j++ {
+		dstIdx := 4 * ((r.Min.Y+j)*i.width + r.Min.X)
+		srcIdx := 4 * j * r.Dx()
+		copy(i.pixels[dstIdx:dstIdx+4*r.Dx()], p[srcIdx:srcIdx+4*r.Dx()])
+	}
+	i.baseColor = nil
+	if intersects {
+		// We can no longer tell which pixels in i.pixels came from the
+		// overlapping draws versus this patch, so the whole log must be
+		// invalidated.
+		i.history = nil
+	} else {
+		i.history = append(i.history, &replacePixelsHistoryItem{
+			pixels: append([]uint8(nil), p...),
+			rect:   r,
+		})
+	}
+	return i.image.ReplacePixelsAt(p, r)
+}
+
+// historyIntersects reports whether r overlaps the destination bounds of any
+// drawImageHistoryItem currently recorded.
+func (i *imageImpl) historyIntersects(r image.Rectangle) bool {
+	for _, item := range i.history {
+		d, ok := item.(*drawImageHistoryItem)
+		if !ok {
+			continue
+		}
+		if d.dstBounds().Overlaps(r) {
+			return true
+		}
+	}
+	return false
+}
+
 func (i *imageImpl) isDisposed() bool {
 	i.m.Lock()
 	defer i.m.Unlock()
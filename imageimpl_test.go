@@ -0,0 +1,56 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"image"
+	"testing"
+)
+
+func TestImageImplHistoryIntersectsUsesGeomTransformedBounds(t *testing.T) {
+	var geom GeoM
+	geom.Translate(100, 100)
+
+	// A 4x4 quad drawn at the local origin, placed at (100,100) by geom.
+	vertices := []int16{
+		0, 0, 0, 0,
+		4, 0, 4, 0,
+		0, 4, 0, 4,
+		4, 4, 4, 4,
+	}
+	i := &imageImpl{
+		width:  200,
+		height: 200,
+		pixels: make([]uint8, 200*200*4),
+		history: []historyItem{
+			&drawImageHistoryItem{vertices: vertices, geom: geom},
+		},
+	}
+
+	want := image.Rect(100, 100, 104, 104)
+	if got := i.history[0].(*drawImageHistoryItem).dstBounds(); got != want {
+		t.Fatalf("dstBounds() = %v, want %v", got, want)
+	}
+
+	// A naive implementation that ignores geom would report an
+	// intersection here, since this is the quad's untransformed local
+	// bounds, even though the draw actually landed at (100,100)-(104,104).
+	if i.historyIntersects(image.Rect(0, 0, 4, 4)) {
+		t.Errorf("historyIntersects((0,0)-(4,4)) = true, want false: the draw is actually at %v", want)
+	}
+	if !i.historyIntersects(image.Rect(102, 102, 106, 106)) {
+		t.Errorf("historyIntersects((102,102)-(106,106)) = false, want true: it overlaps %v", want)
+	}
+}
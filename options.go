@@ -0,0 +1,76 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"github.com/hajimehoshi/ebiten/internal/graphics/opengl"
+)
+
+// ImageParts is an interface representing a list of parts of an image.
+type ImageParts interface {
+	Len() int
+	Dst(index int) (x0, y0, x1, y1 int)
+	Src(index int) (x0, y0, x1, y1 int)
+}
+
+// CompositeMode represents the mode of composition (blending) at the time of drawing.
+type CompositeMode int
+
+// CompositeModes
+const (
+	CompositeModeSourceOver CompositeMode = CompositeMode(opengl.CompositeModeSourceOver)
+	CompositeModeClear      CompositeMode = CompositeMode(opengl.CompositeModeClear)
+)
+
+// DrawImageOptions represents options for Image.DrawImage.
+type DrawImageOptions struct {
+	// Parts is deprecated as of 1.1.0-alpha. Use ImageParts instead.
+	Parts []ImagePart
+
+	// ImageParts is a list of parts of the source image.
+	ImageParts ImageParts
+
+	// GeoM is a geometry matrix to draw.
+	GeoM GeoM
+
+	// ColorM is a color matrix to draw.
+	ColorM ColorM
+
+	// CompositeMode is a composite mode to draw.
+	CompositeMode CompositeMode
+
+	// Shader, if non-nil, overrides the default texturing/compositing
+	// fragment shader used to draw the source image onto the destination.
+	//
+	// The shader is compiled lazily on first use and recompiled
+	// automatically after a GL context loss, so callers don't need to
+	// worry about the lifetime of the underlying GL program.
+	//
+	// Shader and BlendFunc cannot be used together: DrawImage returns an
+	// error if both are non-nil.
+	Shader *Shader
+
+	// Uniforms holds the values bound to the uniform variables declared by
+	// Shader. It is ignored when Shader is nil.
+	Uniforms []Uniform
+
+	// BlendFunc, if non-nil, overrides CompositeMode with a fully custom
+	// GL blend state, letting callers express modes CompositeMode can't,
+	// such as additive, multiply, screen, subtract, and min/max blending.
+	//
+	// BlendFunc and Shader cannot be used together: DrawImage returns an
+	// error if both are non-nil.
+	BlendFunc *BlendFunc
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/hajimehoshi/ebiten/internal/graphics"
+)
+
+// volatilePoolMaxSize is the largest power-of-two bucket the volatile image
+// pool will recycle. Anything bigger is allocated and disposed directly,
+// since such render targets are rare and not worth keeping warm.
+const volatilePoolMaxSize = 4096
+
+// volatileImagePool is a pool of volatile render-target textures, bucketed
+// by the smallest power-of-two square that contains the requested size.
+// acquire reuses the most recently released page of a bucket; released
+// pages are kept until reused, and the pool has no size bound and never
+// evicts or disposes a page. Volatile images are cleared every frame by clearIfVolatile and
+// never need to keep their previous contents, so their backing GL textures
+// can be recycled across frames instead of constantly allocated and freed,
+// which avoids GL texture churn.
+type volatileImagePool struct {
+	m       sync.Mutex
+	buckets map[int][]*graphics.Image
+}
+
+var theVolatileImagePool = &volatileImagePool{
+	buckets: map[int][]*graphics.Image{},
+}
+
+func pow2(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
+	}
+	return p
+}
+
+// acquire returns a width x height image view, reusing the most recently
+// released page for its bucket when one is available. page is the pooled
+// backing texture to hand back to release later; it is nil if the image
+// was allocated directly and isn't pooled.
+func (p *volatileImagePool) acquire(width, height int, filter Filter) (img *graphics.Image, page *graphics.Image, bucket int, err error) {
+	size := pow2(width)
+	if h := pow2(height); h > size {
+		size = h
+	}
+	if size > volatilePoolMaxSize {
+		img, err = graphics.NewImage(width, height, glFilter(filter))
+		return img, nil, 0, err
+	}
+	p.m.Lock()
+	bucketImgs := p.buckets[size]
+	if n := len(bucketImgs); n > 0 {
+		page = bucketImgs[n-1]
+		p.buckets[size] = bucketImgs[:n-1]
+	}
+	p.m.Unlock()
+	if page == nil {
+		page, err = graphics.NewImage(size, size, glFilter(filter))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	img, err = graphics.NewImageInAtlas(page, 0, 0, width, height, glFilter(filter))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return img, page, size, nil
+}
+
+// release returns page to the pool for reuse by a later acquire call.
+// bucket must be the value acquire returned alongside page; a bucket of 0
+// means the image was never pooled and should be disposed by the caller.
+func (p *volatileImagePool) release(page *graphics.Image, bucket int) {
+	if page == nil || bucket == 0 {
+		return
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.buckets[bucket] = append(p.buckets[bucket], page)
+}
+
+// newVolatileImageImpl creates the imageImpl backing a volatile image,
+// drawing its GL texture from theVolatileImagePool when possible.
+func newVolatileImageImpl(width, height int, filter Filter) (*imageImpl, error) {
+	img, page, bucket, err := theVolatileImagePool.acquire(width, height, filter)
+	if err != nil {
+		return nil, err
+	}
+	i := &imageImpl{
+		image:        img,
+		width:        width,
+		height:       height,
+		filter:       filter,
+		volatile:     true,
+		pixels:       make([]uint8, width*height*4),
+		pooledPage:   page,
+		pooledBucket: bucket,
+	}
+	i.id = registerImageImpl(i)
+	runtime.SetFinalizer(i, (*imageImpl).Dispose)
+	return i, nil
+}
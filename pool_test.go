@@ -0,0 +1,60 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/internal/graphics"
+)
+
+func TestPow2(t *testing.T) {
+	tests := []struct {
+		in, want int
+	}{
+		{0, 1},
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{4, 4},
+		{5, 8},
+		{100, 128},
+		{128, 128},
+	}
+	for _, tt := range tests {
+		if got := pow2(tt.in); got != tt.want {
+			t.Errorf("pow2(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVolatileImagePoolReleaseAddsPageToBucket(t *testing.T) {
+	p := &volatileImagePool{buckets: map[int][]*graphics.Image{}}
+	page := &graphics.Image{}
+	p.release(page, 16)
+
+	got := p.buckets[16]
+	if len(got) != 1 || got[0] != page {
+		t.Fatalf("buckets[16] = %v, want a single entry containing the released page", got)
+	}
+}
+
+func TestVolatileImagePoolReleaseIgnoresUnpooledImages(t *testing.T) {
+	p := &volatileImagePool{buckets: map[int][]*graphics.Image{}}
+	p.release(nil, 0)
+	if len(p.buckets) != 0 {
+		t.Errorf("buckets = %v, want empty: a bucket of 0 means the image was never pooled", p.buckets)
+	}
+}
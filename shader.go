@@ -0,0 +1,85 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"sync"
+
+	"github.com/hajimehoshi/ebiten/internal/graphics/opengl"
+)
+
+// UniformType represents the type of a value bound to a shader's uniform
+// variable.
+type UniformType int
+
+// UniformTypes
+const (
+	UniformFloat UniformType = iota
+	UniformVec2
+	UniformVec3
+	UniformVec4
+	UniformMat4
+	UniformImage
+)
+
+// Uniform represents a single value bound to a uniform variable of a Shader.
+//
+// Which fields are read depends on Type: UniformFloat reads Value[0],
+// UniformVec2/Vec3/Vec4 read the leading 2, 3 or 4 elements of Value,
+// UniformMat4 reads Mat4, and UniformImage reads Image.
+type Uniform struct {
+	Name  string
+	Type  UniformType
+	Value [4]float64
+	Mat4  [16]float64
+	Image *Image
+}
+
+// Shader represents a user-supplied fragment shader program that can be used
+// in place of the default texturing/compositing shader when drawing an
+// image with Image.DrawImage.
+//
+// A Shader is compiled lazily the first time it is used and the compiled GL
+// program is cached and shared by every DrawImage call that references it.
+// The program is recompiled automatically after a GL context loss.
+type Shader struct {
+	src string
+
+	m       sync.Mutex
+	program *opengl.Program
+}
+
+// NewShader creates a new Shader from GLSL fragment shader source.
+//
+// The shader is not compiled until it is first used in a DrawImage call.
+func NewShader(src string) (*Shader, error) {
+	return &Shader{src: src}, nil
+}
+
+// compile returns the compiled GL program for this shader, (re)compiling it
+// against context if necessary.
+func (s *Shader) compile(context *opengl.Context) (*opengl.Program, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.program != nil && !s.program.IsInvalidated(context) {
+		return s.program, nil
+	}
+	p, err := opengl.NewShaderProgram(context, s.src)
+	if err != nil {
+		return nil, err
+	}
+	s.program = p
+	return s.program, nil
+}
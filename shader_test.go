@@ -0,0 +1,31 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import "testing"
+
+func TestNewShaderDoesNotCompileEagerly(t *testing.T) {
+	const src = "void main() {}"
+	s, err := NewShader(src)
+	if err != nil {
+		t.Fatalf("NewShader returned an error: %v", err)
+	}
+	if s.src != src {
+		t.Errorf("src = %q, want %q", s.src, src)
+	}
+	if s.program != nil {
+		t.Errorf("program = %v, want nil: a Shader shouldn't compile until first used", s.program)
+	}
+}
@@ -0,0 +1,463 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hajimehoshi/ebiten/internal/graphics/opengl"
+	"github.com/hajimehoshi/ebiten/internal/ui"
+)
+
+// snapshotVersion is bumped whenever the binary layout below changes in a
+// way older readers can't cope with.
+const snapshotVersion = 1
+
+const (
+	snapshotMagicImage = "EBS1"
+	snapshotMagicFrame = "EBSF"
+)
+
+const (
+	historyKindDraw = iota
+	historyKindReplace
+)
+
+var nextImageID uint64
+
+var (
+	imageRegistryM sync.Mutex
+	imageRegistry  = map[uint64]*imageImpl{}
+)
+
+// registerImageImpl assigns i a process-wide stable ID and makes it
+// reachable by that ID for snapshotting, then returns the ID.
+func registerImageImpl(i *imageImpl) uint64 {
+	id := atomic.AddUint64(&nextImageID, 1)
+	imageRegistryM.Lock()
+	imageRegistry[id] = i
+	imageRegistryM.Unlock()
+	return id
+}
+
+func unregisterImageImpl(id uint64) {
+	imageRegistryM.Lock()
+	delete(imageRegistry, id)
+	imageRegistryM.Unlock()
+}
+
+func lookupImageImpl(id uint64) *imageImpl {
+	imageRegistryM.Lock()
+	defer imageRegistryM.Unlock()
+	return imageRegistry[id]
+}
+
+// uniformDTO is the serialized form of a Uniform: Image is replaced by the
+// stable ID of the referenced image, since the image itself is serialized
+// (and resolved) independently.
+type uniformDTO struct {
+	Name    string
+	Type    UniformType
+	Value   [4]float64
+	Mat4    [16]float64
+	ImageID uint64
+}
+
+// historyDTO is the serialized form of either a drawImageHistoryItem or a
+// replacePixelsHistoryItem, tagged by Kind so history can be replayed in
+// its original interleaved order.
+type historyDTO struct {
+	Kind int
+
+	// Populated when Kind == historyKindDraw.
+	SrcID     uint64
+	Vertices  []int16
+	Geom      GeoM
+	Colorm    ColorM
+	Mode      int
+	ShaderSrc string
+	Uniforms  []uniformDTO
+	BlendFunc *BlendFunc
+
+	// Populated when Kind == historyKindReplace.
+	Pixels                         []byte
+	RectX0, RectY0, RectX1, RectY1 int
+}
+
+// snapshotDTO is the versioned, serializable snapshot of a single
+// imageImpl's state: its pixels, flags, and any pending history needed to
+// reproduce the image after a GL context loss.
+type snapshotDTO struct {
+	ID           uint64
+	Width        int
+	Height       int
+	Filter       Filter
+	Volatile     bool
+	Screen       bool
+	Pixels       []byte
+	HasBaseColor bool
+	BaseColor    [4]uint32
+	History      []historyDTO
+}
+
+// toDTO captures i's current state. The caller must already hold i.m, or i
+// must not yet be reachable from other goroutines.
+func (i *imageImpl) toDTO() snapshotDTO {
+	d := snapshotDTO{
+		ID:       i.id,
+		Width:    i.width,
+		Height:   i.height,
+		Filter:   i.filter,
+		Volatile: i.volatile,
+		Screen:   i.screen,
+	}
+	if i.pixels != nil {
+		d.Pixels = append([]byte(nil), i.pixels...)
+	}
+	if i.baseColor != nil {
+		r, g, b, a := i.baseColor.RGBA()
+		d.HasBaseColor = true
+		d.BaseColor = [4]uint32{r, g, b, a}
+	}
+	for _, item := range i.history {
+		switch c := item.(type) {
+		case *drawImageHistoryItem:
+			h := historyDTO{
+				Kind:     historyKindDraw,
+				SrcID:    c.image.impl.id,
+				Vertices: append([]int16(nil), c.vertices...),
+				Geom:     c.geom,
+				Colorm:   c.colorm,
+				Mode:     int(c.mode),
+			}
+			if c.blendFunc != nil {
+				bf := *c.blendFunc
+				h.BlendFunc = &bf
+			}
+			if c.shader != nil {
+				h.ShaderSrc = c.shader.src
+				h.Uniforms = make([]uniformDTO, len(c.uniforms))
+				for idx, u := range c.uniforms {
+					ud := uniformDTO{Name: u.Name, Type: u.Type, Value: u.Value, Mat4: u.Mat4}
+					if u.Type == UniformImage && u.Image != nil {
+						ud.ImageID = u.Image.impl.id
+					}
+					h.Uniforms[idx] = ud
+				}
+			}
+			d.History = append(d.History, h)
+		case *replacePixelsHistoryItem:
+			d.History = append(d.History, historyDTO{
+				Kind:   historyKindReplace,
+				Pixels: append([]byte(nil), c.pixels...),
+				RectX0: c.rect.Min.X,
+				RectY0: c.rect.Min.Y,
+				RectX1: c.rect.Max.X,
+				RectY1: c.rect.Max.Y,
+			})
+		}
+	}
+	return d
+}
+
+// newImageImplFromDTO rebuilds an imageImpl from a previously captured
+// snapshotDTO. Any draw history referencing another image is only
+// reproducible if that image is still alive under the same stable ID
+// (true within a single process across a SnapshotFrame/RestoreFrame pair;
+// not true once the process has restarted).
+func newImageImplFromDTO(d snapshotDTO) (*imageImpl, error) {
+	i := &imageImpl{
+		width:    d.Width,
+		height:   d.Height,
+		filter:   d.Filter,
+		volatile: d.Volatile,
+		screen:   d.Screen,
+		pixels:   append([]byte(nil), d.Pixels...),
+	}
+	if d.HasBaseColor {
+		i.baseColor = color.RGBA64{R: uint16(d.BaseColor[0]), G: uint16(d.BaseColor[1]), B: uint16(d.BaseColor[2]), A: uint16(d.BaseColor[3])}
+	}
+	for _, h := range d.History {
+		switch h.Kind {
+		case historyKindDraw:
+			src := lookupImageImpl(h.SrcID)
+			if src == nil {
+				return nil, fmt.Errorf("ebiten: snapshot references image %d which is no longer available", h.SrcID)
+			}
+			c := &drawImageHistoryItem{
+				image:     &Image{impl: src},
+				vertices:  h.Vertices,
+				geom:      h.Geom,
+				colorm:    h.Colorm,
+				mode:      opengl.CompositeMode(h.Mode),
+				blendFunc: h.BlendFunc,
+			}
+			if h.ShaderSrc != "" {
+				c.shader = &Shader{src: h.ShaderSrc}
+				c.uniforms = make([]Uniform, len(h.Uniforms))
+				for idx, ud := range h.Uniforms {
+					u := Uniform{Name: ud.Name, Type: ud.Type, Value: ud.Value, Mat4: ud.Mat4}
+					if ud.Type == UniformImage && ud.ImageID != 0 {
+						if imgImpl := lookupImageImpl(ud.ImageID); imgImpl != nil {
+							u.Image = &Image{impl: imgImpl}
+						}
+					}
+					c.uniforms[idx] = u
+				}
+			}
+			i.history = append(i.history, c)
+		case historyKindReplace:
+			i.history = append(i.history, &replacePixelsHistoryItem{
+				pixels: append([]byte(nil), h.Pixels...),
+				rect:   image.Rect(h.RectX0, h.RectY0, h.RectX1, h.RectY1),
+			})
+		}
+	}
+	i.id = registerImageImpl(i)
+	runtime.SetFinalizer(i, (*imageImpl).Dispose)
+	// Reuse the ordinary context-loss recovery path to turn the restored
+	// pixels/history back into a live GL texture.
+	if err := i.restore(ui.GLContext()); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func encodeSnapshot(magic string, v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString(magic)
+	if err := binary.Write(buf, binary.LittleEndian, uint32(snapshotVersion)); err != nil {
+		return nil, err
+	}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSnapshot(magic string, data []byte, v interface{}) error {
+	if len(data) < len(magic)+4 {
+		return errors.New("ebiten: snapshot data is too short")
+	}
+	if string(data[:len(magic)]) != magic {
+		return errors.New("ebiten: snapshot has an unrecognized header")
+	}
+	version := binary.LittleEndian.Uint32(data[len(magic) : len(magic)+4])
+	if version != snapshotVersion {
+		return fmt.Errorf("ebiten: snapshot version %d is not supported", version)
+	}
+	return gob.NewDecoder(bytes.NewReader(data[len(magic)+4:])).Decode(v)
+}
+
+// Snapshot serializes i's pixels, filter, volatile/screen flags, and any
+// pending draw history into a versioned binary format. The result can
+// later be restored with LoadImageSnapshot.
+//
+// Draw history that references another image can only be replayed if that
+// image is still alive with the same stable ID, which in practice means
+// Snapshot/LoadImageSnapshot on their own are best suited to images with no
+// pending history (e.g. right after Fill or ReplacePixels); use
+// SnapshotFrame/RestoreFrame to capture a whole, self-consistent frame.
+func (i *Image) Snapshot() ([]byte, error) {
+	i.impl.m.Lock()
+	d := i.impl.toDTO()
+	i.impl.m.Unlock()
+	return encodeSnapshot(snapshotMagicImage, d)
+}
+
+// LoadImageSnapshot creates a new Image from data previously returned by
+// (*Image).Snapshot.
+func LoadImageSnapshot(data []byte) (*Image, error) {
+	var d snapshotDTO
+	if err := decodeSnapshot(snapshotMagicImage, data, &d); err != nil {
+		return nil, err
+	}
+	impl, err := newImageImplFromDTO(d)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{impl: impl}, nil
+}
+
+// frameSnapshotDTO bundles every live image's snapshotDTO together so that
+// cross-references between images (draw history, shader image uniforms)
+// resolve correctly on restore.
+type frameSnapshotDTO struct {
+	Images []snapshotDTO
+}
+
+// SnapshotFrame serializes the state of every currently live Image into a
+// single versioned blob, suitable for implementing rewind, netcode
+// rollback, or reproducible bug reports. Pair it with RestoreFrame.
+func SnapshotFrame() ([]byte, error) {
+	imageRegistryM.Lock()
+	impls := make([]*imageImpl, 0, len(imageRegistry))
+	for _, impl := range imageRegistry {
+		impls = append(impls, impl)
+	}
+	imageRegistryM.Unlock()
+
+	dto := frameSnapshotDTO{}
+	for _, impl := range impls {
+		impl.m.Lock()
+		dto.Images = append(dto.Images, impl.toDTO())
+		impl.m.Unlock()
+	}
+	return encodeSnapshot(snapshotMagicFrame, dto)
+}
+
+// restoreTarget pairs an imageImpl that is part of a RestoreFrame batch with
+// the snapshotDTO it's being restored from, so the batch can be replayed in
+// dependency order once every image's fields have been reset.
+type restoreTarget struct {
+	impl *imageImpl
+	dto  snapshotDTO
+}
+
+// RestoreFrame restores every image captured by a prior SnapshotFrame call,
+// matching them up by their stable ID. Images that no longer exist are
+// skipped; images created since the snapshot was taken are left untouched.
+//
+// Restoring happens in two passes. The first resets every image's
+// pixels/history from its DTO with no GL replay, so that when the second
+// pass calls restore (and thereby replays any draw referencing another
+// image in the same batch), that other image's history already reflects
+// its own restored state rather than whatever it held before RestoreFrame
+// was called. The second pass then replays each image in dependency
+// order, since an image can only be correctly restored once every other
+// batch image its history draws from has itself been restored.
+func RestoreFrame(data []byte) error {
+	var dto frameSnapshotDTO
+	if err := decodeSnapshot(snapshotMagicFrame, data, &dto); err != nil {
+		return err
+	}
+
+	targets := make([]*restoreTarget, 0, len(dto.Images))
+	pending := map[uint64]bool{}
+	for _, d := range dto.Images {
+		impl := lookupImageImpl(d.ID)
+		if impl == nil {
+			continue
+		}
+		targets = append(targets, &restoreTarget{impl: impl, dto: d})
+		pending[d.ID] = true
+	}
+
+	for _, t := range targets {
+		impl, d := t.impl, t.dto
+		impl.m.Lock()
+		impl.width = d.Width
+		impl.height = d.Height
+		impl.filter = d.Filter
+		impl.pixels = append([]byte(nil), d.Pixels...)
+		impl.baseColor = nil
+		if d.HasBaseColor {
+			impl.baseColor = color.RGBA64{R: uint16(d.BaseColor[0]), G: uint16(d.BaseColor[1]), B: uint16(d.BaseColor[2]), A: uint16(d.BaseColor[3])}
+		}
+		impl.history = nil
+		for _, h := range d.History {
+			switch h.Kind {
+			case historyKindDraw:
+				src := lookupImageImpl(h.SrcID)
+				if src == nil {
+					continue
+				}
+				c := &drawImageHistoryItem{
+					image:     &Image{impl: src},
+					vertices:  h.Vertices,
+					geom:      h.Geom,
+					colorm:    h.Colorm,
+					mode:      opengl.CompositeMode(h.Mode),
+					blendFunc: h.BlendFunc,
+				}
+				if h.ShaderSrc != "" {
+					c.shader = &Shader{src: h.ShaderSrc}
+					c.uniforms = make([]Uniform, len(h.Uniforms))
+					for idx, ud := range h.Uniforms {
+						u := Uniform{Name: ud.Name, Type: ud.Type, Value: ud.Value, Mat4: ud.Mat4}
+						if ud.Type == UniformImage && ud.ImageID != 0 {
+							if imgImpl := lookupImageImpl(ud.ImageID); imgImpl != nil {
+								u.Image = &Image{impl: imgImpl}
+							}
+						}
+						c.uniforms[idx] = u
+					}
+				}
+				impl.history = append(impl.history, c)
+			case historyKindReplace:
+				impl.history = append(impl.history, &replacePixelsHistoryItem{
+					pixels: append([]byte(nil), h.Pixels...),
+					rect:   image.Rect(h.RectX0, h.RectY0, h.RectX1, h.RectY1),
+				})
+			}
+		}
+		impl.m.Unlock()
+	}
+
+	restored := map[uint64]bool{}
+	remaining := targets
+	for len(remaining) > 0 {
+		next := remaining[:0]
+		progressed := false
+		for _, t := range remaining {
+			if dependsOnUnrestored(t.impl, pending, restored) {
+				next = append(next, t)
+				continue
+			}
+			// restore itself detaches t.impl from any stale atlas page or
+			// pooled texture before rebuilding it, so calling it here is
+			// safe for atlas-packed and pooled images too, not just
+			// standalone ones.
+			if err := t.impl.restore(ui.GLContext()); err != nil {
+				return err
+			}
+			restored[t.dto.ID] = true
+			progressed = true
+		}
+		remaining = next
+		if !progressed {
+			return errors.New("ebiten: RestoreFrame found a cyclic draw dependency between images")
+		}
+	}
+	return nil
+}
+
+// dependsOnUnrestored reports whether impl's pending draw history still
+// references another image that is part of the same RestoreFrame batch
+// (pending) but hasn't been restored yet (restored).
+func dependsOnUnrestored(impl *imageImpl, pending, restored map[uint64]bool) bool {
+	impl.m.Lock()
+	defer impl.m.Unlock()
+	for _, item := range impl.history {
+		c, ok := item.(*drawImageHistoryItem)
+		if !ok {
+			continue
+		}
+		srcID := c.image.impl.id
+		if pending[srcID] && !restored[srcID] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Hajime Hoshi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebiten
+
+import "testing"
+
+func TestDependsOnUnrestoredWaitsForBatchDependency(t *testing.T) {
+	src := &imageImpl{id: 1}
+	dst := &imageImpl{
+		id: 2,
+		history: []historyItem{
+			&drawImageHistoryItem{image: &Image{impl: src}},
+		},
+	}
+	pending := map[uint64]bool{1: true, 2: true}
+	restored := map[uint64]bool{}
+
+	if !dependsOnUnrestored(dst, pending, restored) {
+		t.Fatal("dependsOnUnrestored = false, want true: src is in the same batch and hasn't been restored yet")
+	}
+
+	restored[1] = true
+	if dependsOnUnrestored(dst, pending, restored) {
+		t.Fatal("dependsOnUnrestored = true, want false: src has already been restored")
+	}
+}
+
+func TestDependsOnUnrestoredIgnoresImagesOutsideTheBatch(t *testing.T) {
+	src := &imageImpl{id: 99}
+	dst := &imageImpl{
+		id: 2,
+		history: []historyItem{
+			&drawImageHistoryItem{image: &Image{impl: src}},
+		},
+	}
+	// src (99) isn't part of this RestoreFrame batch, so its own state is
+	// whatever it already was and shouldn't block dst from restoring.
+	pending := map[uint64]bool{2: true}
+	restored := map[uint64]bool{}
+
+	if dependsOnUnrestored(dst, pending, restored) {
+		t.Fatal("dependsOnUnrestored = true, want false: src isn't part of this batch")
+	}
+}